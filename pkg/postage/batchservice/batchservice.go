@@ -0,0 +1,410 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package batchservice applies postage batch events relayed by a chain
+// listener to a batch store, keeping a running checksum of applied events
+// so that a restarted node can tell whether it is still in sync with the
+// state it persisted before shutting down.
+package batchservice
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"sync"
+
+	"github.com/ethersphere/bee/pkg/logging"
+	"github.com/ethersphere/bee/pkg/postage"
+	"github.com/ethersphere/bee/pkg/storage"
+)
+
+func sha256New() hash.Hash { return sha256.New() }
+
+const (
+	checksumKey = "batchservice_checksum"
+	dirtyKey    = "batchservice_dirty_shutdown"
+
+	// DefaultReorgDepth is the number of most recently applied events kept
+	// around so that a short chain reorg can be undone without a full
+	// resync. It can be overridden with SetReorgDepth; no command-line
+	// flag sets it.
+	DefaultReorgDepth = 12
+)
+
+type eventKind int
+
+const (
+	eventCreate eventKind = iota
+	eventTopUp
+	eventUpdateDepth
+	eventUpdatePrice
+	eventUpdateBlockNumber
+)
+
+// bufferedEvent is a snapshot of the state a single applied event
+// overwrote, keyed by the block number it was applied at, so that Reorg
+// can undo it in reverse order.
+type bufferedEvent struct {
+	block    uint64
+	kind     eventKind
+	batchID  []byte
+	batch    *postage.Batch      // previous value of the batch, nil if it did not exist before this event
+	chainSt  *postage.ChainState // previous chain state, for chain-state-only events
+	checksum []byte              // checksum before this event was applied
+}
+
+type service struct {
+	mu sync.Mutex
+
+	stateStore storage.StateStorer
+	store      postage.Storer
+	logger     logging.Logger
+	listener   postage.Listener
+
+	owner         []byte
+	batchListener postage.BatchCreationListener
+
+	hasherFunc func() hash.Hash
+	checksum   []byte // digest of every event applied so far, chained as H(checksum || event)
+
+	reorgDepth int
+	buffer     []bufferedEvent
+
+	// minUndoBlock is the oldest block Reorg can still safely undo back
+	// to: recordEvent raises it to its eviction threshold on every call,
+	// since events at or below that threshold are considered final and
+	// are evicted, and the buffer can no longer vouch for
+	// everything that happened after an ancestor older than this.
+	minUndoBlock uint64
+
+	// resumeBlock is set by NewWithCheckpoint to make the next Start call
+	// begin listening right after the checkpoint block, bypassing the
+	// dirty-shutdown recovery check since the store did not come from a
+	// live sync.
+	resumeBlock *uint64
+
+	subMu     sync.Mutex
+	subs      map[uint64]*subscriber
+	nextSubID uint64
+	dropped   uint64
+}
+
+// New creates a new batchservice.Service. If resync is true, the checksum
+// persisted from a previous run is ignored and the running checksum starts
+// from scratch, as happens when the node is resyncing from block 0.
+func New(
+	stateStore storage.StateStorer,
+	store postage.Storer,
+	logger logging.Logger,
+	listener postage.Listener,
+	owner []byte,
+	batchListener postage.BatchCreationListener,
+	hasherFunc func() hash.Hash,
+	resync bool,
+) (EventSource, error) {
+	if hasherFunc == nil {
+		hasherFunc = sha256New
+	}
+
+	svc := &service{
+		stateStore:    stateStore,
+		store:         store,
+		logger:        logger,
+		listener:      listener,
+		owner:         owner,
+		batchListener: batchListener,
+		hasherFunc:    hasherFunc,
+		reorgDepth:    DefaultReorgDepth,
+		subs:          make(map[uint64]*subscriber),
+	}
+
+	if !resync {
+		var sum []byte
+		err := stateStore.Get(checksumKey, &sum)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("get checksum: %w", err)
+		}
+		if err == nil {
+			svc.checksum = sum
+		}
+	}
+
+	return svc, nil
+}
+
+// SetReorgDepth configures how many applied events are kept buffered for
+// Reorg to undo. It must be called before Start.
+func (s *service) SetReorgDepth(depth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reorgDepth = depth
+}
+
+func (s *service) Create(id, owner []byte, normalisedBalance *big.Int, depth, bucketDepth uint8, immutable bool, txHash []byte) error {
+	cs := s.store.GetChainState()
+	batch := &postage.Batch{
+		ID:          id,
+		Owner:       owner,
+		Value:       normalisedBalance,
+		Start:       cs.Block,
+		Depth:       depth,
+		BucketDepth: bucketDepth,
+		Immutable:   immutable,
+	}
+
+	if err := s.store.Put(batch, normalisedBalance, depth); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+
+	s.recordEvent(eventCreate, cs.Block, id, nil, nil)
+
+	if err := s.writeChecksum(id, normalisedBalance.Bytes()); err != nil {
+		return err
+	}
+
+	if s.batchListener != nil && bytes.Equal(s.owner, owner) {
+		s.batchListener.Handle(batch)
+	}
+
+	s.publish(BatchCreated{
+		BatchID:           id,
+		Owner:             owner,
+		NormalisedBalance: normalisedBalance,
+		Depth:             depth,
+		BucketDepth:       bucketDepth,
+		Immutable:         immutable,
+		TxHash:            txHash,
+		BlockNumber:       cs.Block,
+	})
+
+	return nil
+}
+
+func (s *service) TopUp(id []byte, normalisedBalance *big.Int, txHash []byte) error {
+	batch, err := s.store.Get(id)
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+
+	prev := *batch
+	if err := s.store.Put(batch, normalisedBalance, batch.Depth); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+
+	block := s.store.GetChainState().Block
+	s.recordEvent(eventTopUp, block, id, &prev, nil)
+
+	if err := s.writeChecksum(id, normalisedBalance.Bytes()); err != nil {
+		return err
+	}
+
+	s.publish(BatchToppedUp{BatchID: id, NormalisedBalance: normalisedBalance, TxHash: txHash, BlockNumber: block})
+	return nil
+}
+
+func (s *service) UpdateDepth(id []byte, depth uint8, normalisedBalance *big.Int, txHash []byte) error {
+	batch, err := s.store.Get(id)
+	if err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+
+	prev := *batch
+	if err := s.store.Put(batch, normalisedBalance, depth); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+
+	block := s.store.GetChainState().Block
+	s.recordEvent(eventUpdateDepth, block, id, &prev, nil)
+
+	if err := s.writeChecksum(id, []byte{depth}); err != nil {
+		return err
+	}
+
+	s.publish(BatchDepthUpdated{BatchID: id, Depth: depth, NormalisedBalance: normalisedBalance, TxHash: txHash, BlockNumber: block})
+	return nil
+}
+
+func (s *service) UpdatePrice(price *big.Int, txHash []byte) error {
+	cs := s.store.GetChainState()
+	prev := *cs
+	next := &postage.ChainState{Block: cs.Block, TotalAmount: cs.TotalAmount, CurrentPrice: price}
+
+	if err := s.store.PutChainState(next); err != nil {
+		return fmt.Errorf("put chain state: %w", err)
+	}
+
+	s.recordEvent(eventUpdatePrice, cs.Block, nil, nil, &prev)
+
+	if err := s.writeChecksum(price.Bytes(), txHash); err != nil {
+		return err
+	}
+
+	s.publish(PriceUpdated{Price: price, TxHash: txHash, BlockNumber: cs.Block})
+	return nil
+}
+
+func (s *service) UpdateBlockNumber(blockNumber uint64) error {
+	cs := s.store.GetChainState()
+	prev := *cs
+
+	total := new(big.Int).Add(cs.TotalAmount, new(big.Int).Mul(big.NewInt(int64(blockNumber-cs.Block)), cs.CurrentPrice))
+	next := &postage.ChainState{Block: blockNumber, TotalAmount: total, CurrentPrice: cs.CurrentPrice}
+
+	if err := s.store.PutChainState(next); err != nil {
+		return fmt.Errorf("put chain state: %w", err)
+	}
+
+	s.recordEvent(eventUpdateBlockNumber, blockNumber, nil, nil, &prev)
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], blockNumber)
+	if err := s.writeChecksum(buf[:], total.Bytes()); err != nil {
+		return err
+	}
+
+	s.publish(BlockNumberUpdated{BlockNumber: blockNumber})
+	return nil
+}
+
+// Reorg undoes every buffered event applied after commonAncestor, in
+// reverse order, restoring the batch and chain state values each event
+// overwrote, then rewinds the chain state to commonAncestor so that
+// Listen can resume forward application from there. If commonAncestor
+// predates everything the buffer can still vouch for - a reorg deeper
+// than reorgDepth, or one racing an eviction - Reorg refuses to guess
+// and returns an error instead of silently leaving some events applied
+// while reporting success; the caller should fall back to a full Reset
+// and resync, the same way it already does for an unclean shutdown.
+func (s *service) Reorg(commonAncestor uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if commonAncestor < s.minUndoBlock {
+		return fmt.Errorf("reorg: common ancestor block %d predates the oldest block the event buffer can still undo back to (%d): full resync required", commonAncestor, s.minUndoBlock)
+	}
+
+	for len(s.buffer) > 0 {
+		ev := s.buffer[len(s.buffer)-1]
+		if ev.block <= commonAncestor {
+			break
+		}
+		if err := s.undo(ev); err != nil {
+			return fmt.Errorf("undo event at block %d: %w", ev.block, err)
+		}
+		s.buffer = s.buffer[:len(s.buffer)-1]
+	}
+
+	cs := s.store.GetChainState()
+	next := &postage.ChainState{Block: commonAncestor, TotalAmount: cs.TotalAmount, CurrentPrice: cs.CurrentPrice}
+	return s.store.PutChainState(next)
+}
+
+func (s *service) undo(ev bufferedEvent) error {
+	s.checksum = ev.checksum
+	if err := s.stateStore.Put(checksumKey, s.checksum); err != nil {
+		return fmt.Errorf("put checksum: %w", err)
+	}
+
+	switch ev.kind {
+	case eventCreate:
+		return s.store.Delete(ev.batchID)
+	case eventTopUp, eventUpdateDepth:
+		return s.store.Put(ev.batch, ev.batch.Value, ev.batch.Depth)
+	case eventUpdatePrice, eventUpdateBlockNumber:
+		return s.store.PutChainState(ev.chainSt)
+	default:
+		return nil
+	}
+}
+
+// recordEvent buffers the previous state an event overwrote and drops
+// events that are reorgDepth or more blocks behind the current chain tip,
+// as those are considered final.
+func (s *service) recordEvent(kind eventKind, block uint64, batchID []byte, batch *postage.Batch, cs *postage.ChainState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buffer = append(s.buffer, bufferedEvent{block: block, kind: kind, batchID: batchID, batch: batch, chainSt: cs, checksum: s.checksum})
+
+	threshold := int64(block) - int64(s.reorgDepth)
+	i := 0
+	for ; i < len(s.buffer); i++ {
+		if int64(s.buffer[i].block) > threshold {
+			break
+		}
+	}
+	s.buffer = s.buffer[i:]
+
+	if threshold > 0 && uint64(threshold) > s.minUndoBlock {
+		s.minUndoBlock = uint64(threshold)
+	}
+}
+
+func (s *service) TransactionStart() error {
+	return s.stateStore.Put(dirtyKey, true)
+}
+
+// flusher is implemented by Storer wrappers that buffer writes in memory
+// and need an explicit synchronization point, such as
+// batchstore/cached.Store. It is checked with a type assertion rather
+// than being added to postage.Storer, since most Storer implementations
+// write straight through and have nothing to flush.
+type flusher interface {
+	Flush() error
+}
+
+func (s *service) TransactionEnd() error {
+	if f, ok := s.store.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return fmt.Errorf("flush: %w", err)
+		}
+	}
+	return s.stateStore.Delete(dirtyKey)
+}
+
+func (s *service) Start(startBlock uint64) (<-chan struct{}, error) {
+	if s.resumeBlock != nil {
+		resumeBlock := *s.resumeBlock
+		s.resumeBlock = nil
+		return s.listener.Listen(resumeBlock, s), nil
+	}
+
+	var dirty bool
+	err := s.stateStore.Get(dirtyKey, &dirty)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("get dirty shutdown marker: %w", err)
+	}
+
+	if err == nil && dirty {
+		s.logger.Warningf("batch service: unclean shutdown detected, resetting batch store and resyncing from block 0")
+		if err := s.store.Reset(); err != nil {
+			return nil, fmt.Errorf("reset: %w", err)
+		}
+		startBlock = 0
+	}
+
+	return s.listener.Listen(startBlock, s), nil
+}
+
+// writeChecksum chains a new event onto the running checksum:
+// checksum' = H(checksum || event). Using the previous digest rather than
+// the hasher's own accumulated state means a node can resume the chain
+// from any previously persisted (or checkpointed) digest and still land
+// on the same checksum as a node that replayed every event from genesis.
+func (s *service) writeChecksum(data ...[]byte) error {
+	h := s.hasherFunc()
+	h.Write(s.checksum)
+	h.Write(bytes.Join(data, nil))
+	sum := h.Sum(nil)
+	s.checksum = sum
+
+	if err := s.stateStore.Put(checksumKey, sum); err != nil {
+		return fmt.Errorf("put checksum: %w", err)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ package batchservice_test
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"hash"
 	"io/ioutil"
@@ -16,6 +17,7 @@ import (
 	"github.com/ethersphere/bee/pkg/logging"
 	"github.com/ethersphere/bee/pkg/postage"
 	"github.com/ethersphere/bee/pkg/postage/batchservice"
+	"github.com/ethersphere/bee/pkg/postage/batchstore/cached"
 	"github.com/ethersphere/bee/pkg/postage/batchstore/mock"
 	postagetesting "github.com/ethersphere/bee/pkg/postage/testing"
 	mocks "github.com/ethersphere/bee/pkg/statestore/mock"
@@ -115,6 +117,9 @@ func TestBatchServiceCreate(t *testing.T) {
 			mock.WithChainState(testChainState),
 		)
 
+		events, unsubscribe := svc.Subscribe(batchservice.EventFilter{})
+		defer unsubscribe()
+
 		if err := svc.Create(
 			testBatch.ID,
 			testBatch.Owner,
@@ -130,6 +135,8 @@ func TestBatchServiceCreate(t *testing.T) {
 			t.Fatalf("unexpected batch listener count, exp %d found %d", 1, testBatchListener.count)
 		}
 
+		assertSingleEvent(t, events, batchservice.KindBatchCreated)
+
 		validateBatch(t, testBatch, batchStore)
 	})
 
@@ -147,6 +154,9 @@ func TestBatchServiceCreate(t *testing.T) {
 			mock.WithChainState(testChainState),
 		)
 
+		events, unsubscribe := svc.Subscribe(batchservice.EventFilter{})
+		defer unsubscribe()
+
 		if err := svc.Create(
 			testBatch.ID,
 			testBatch.Owner,
@@ -162,6 +172,10 @@ func TestBatchServiceCreate(t *testing.T) {
 			t.Fatalf("unexpected batch listener count, exp %d found %d", 1, testBatchListener.count)
 		}
 
+		// the pub/sub event still fires regardless of batch ownership;
+		// only the legacy single-owner callback is owner-gated.
+		assertSingleEvent(t, events, batchservice.KindBatchCreated)
+
 		validateBatch(t, testBatch, batchStore)
 	})
 }
@@ -197,6 +211,9 @@ func TestBatchServiceTopUp(t *testing.T) {
 		svc, batchStore, _ := newTestStoreAndService(t)
 		putBatch(t, batchStore, testBatch)
 
+		events, unsubscribe := svc.Subscribe(batchservice.EventFilter{})
+		defer unsubscribe()
+
 		want := testNormalisedBalance
 
 		if err := svc.TopUp(testBatch.ID, testNormalisedBalance, testTxHash); err != nil {
@@ -211,6 +228,8 @@ func TestBatchServiceTopUp(t *testing.T) {
 		if got.Value.Cmp(want) != 0 {
 			t.Fatalf("topped up amount: got %v, want %v", got.Value, want)
 		}
+
+		assertSingleEvent(t, events, batchservice.KindBatchToppedUp)
 	})
 }
 
@@ -246,10 +265,15 @@ func TestBatchServiceUpdateDepth(t *testing.T) {
 		svc, batchStore, _ := newTestStoreAndService(t)
 		putBatch(t, batchStore, testBatch)
 
+		events, unsubscribe := svc.Subscribe(batchservice.EventFilter{})
+		defer unsubscribe()
+
 		if err := svc.UpdateDepth(testBatch.ID, testNewDepth, testNormalisedBalance, testTxHash); err != nil {
 			t.Fatalf("update depth: %v", err)
 		}
 
+		assertSingleEvent(t, events, batchservice.KindBatchDepthUpdated)
+
 		val, err := batchStore.Get(testBatch.ID)
 		if err != nil {
 			t.Fatalf("batch store get: %v", err)
@@ -390,45 +414,250 @@ func TestChecksum(t *testing.T) {
 }
 
 func TestChecksumResync(t *testing.T) {
-	s := mocks.NewStateStore()
-	store := mock.New()
-	mockHash := &hs{}
-	svc, err := batchservice.New(s, store, testLog, newMockListener(), nil, nil, func() hash.Hash { return mockHash }, true)
+	newHasher := func() hash.Hash { return sha256.New() }
+	testBatch := postagetesting.MustNewBatch()
+	testNormalisedBalance := big.NewInt(2000000000000)
+	testNewDepth := testBatch.Depth + 1
+
+	// Uninterrupted run: apply TopUp then UpdateDepth back to back on a
+	// single service, as a baseline to compare a restart against.
+	uninterruptedState := mocks.NewStateStore()
+	uninterruptedStore := mock.New()
+	putBatch(t, uninterruptedStore, testBatch)
+	uninterruptedSvc, err := batchservice.New(uninterruptedState, uninterruptedStore, testLog, newMockListener(), nil, nil, newHasher, false)
 	if err != nil {
 		t.Fatal(err)
 	}
-	testNormalisedBalance := big.NewInt(2000000000000)
+	if err := uninterruptedSvc.TopUp(testBatch.ID, testNormalisedBalance, testTxHash); err != nil {
+		t.Fatalf("top up: %v", err)
+	}
+	if err := uninterruptedSvc.UpdateDepth(testBatch.ID, testNewDepth, testNormalisedBalance, testTxHash); err != nil {
+		t.Fatalf("update depth: %v", err)
+	}
+	wantChecksum := getChecksum(t, uninterruptedState)
+
+	// Restart mid-stream: TopUp is applied, the service is dropped and a
+	// new one is built over the same state store, which reloads the
+	// checksum TopUp persisted; UpdateDepth is then applied on the new
+	// instance. It must land on the same checksum as the uninterrupted run.
+	restartState := mocks.NewStateStore()
+	restartStore := mock.New()
+	putBatch(t, restartStore, testBatch)
+	firstHalf, err := batchservice.New(restartState, restartStore, testLog, newMockListener(), nil, nil, newHasher, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := firstHalf.TopUp(testBatch.ID, testNormalisedBalance, testTxHash); err != nil {
+		t.Fatalf("top up: %v", err)
+	}
+
+	secondHalf, err := batchservice.New(restartState, restartStore, testLog, newMockListener(), nil, nil, newHasher, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := secondHalf.UpdateDepth(testBatch.ID, testNewDepth, testNormalisedBalance, testTxHash); err != nil {
+		t.Fatalf("update depth: %v", err)
+	}
+
+	if got := getChecksum(t, restartState); string(got) != string(wantChecksum) {
+		t.Fatalf("checksum after restart mid-stream: want %x, got %x", wantChecksum, got)
+	}
+
+	// Resync: even though restartState already holds a persisted checksum
+	// from the run above, a service built with resync=true must ignore it
+	// and start the chain from scratch - so replaying just TopUp on a
+	// fresh store must match a fresh, uninterrupted TopUp-only checksum,
+	// not anything derived from the stale persisted value.
+	topUpOnlyState := mocks.NewStateStore()
+	topUpOnlyStore := mock.New()
+	putBatch(t, topUpOnlyStore, testBatch)
+	topUpOnlySvc, err := batchservice.New(topUpOnlyState, topUpOnlyStore, testLog, newMockListener(), nil, nil, newHasher, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := topUpOnlySvc.TopUp(testBatch.ID, testNormalisedBalance, testTxHash); err != nil {
+		t.Fatalf("top up: %v", err)
+	}
+	wantTopUpOnlyChecksum := getChecksum(t, topUpOnlyState)
+
+	resyncStore := mock.New()
+	putBatch(t, resyncStore, testBatch)
+	resyncSvc, err := batchservice.New(restartState, resyncStore, testLog, newMockListener(), nil, nil, newHasher, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resyncSvc.TopUp(testBatch.ID, testNormalisedBalance, testTxHash); err != nil {
+		t.Fatalf("top up: %v", err)
+	}
+
+	if got := getChecksum(t, restartState); string(got) != string(wantTopUpOnlyChecksum) {
+		t.Fatalf("resync should ignore persisted checksum: want %x, got %x", wantTopUpOnlyChecksum, got)
+	}
+}
+
+func getChecksum(t *testing.T, s storage.StateStorer) []byte {
+	t.Helper()
+	var sum []byte
+	if err := s.Get("batchservice_checksum", &sum); err != nil {
+		t.Fatalf("get checksum: %v", err)
+	}
+	return sum
+}
+
+// TestTransactionEndFlushesCachedStore verifies that TransactionEnd
+// synchronously flushes a batchstore/cached.Store, rather than leaving
+// buffered writes to trickle out on the next interval or threshold
+// flush - the "synchronously on TransactionEnd" requirement a cached
+// store sits behind.
+func TestTransactionEndFlushesCachedStore(t *testing.T) {
 	testBatch := postagetesting.MustNewBatch()
-	putBatch(t, store, testBatch)
+	testNormalisedBalance := big.NewInt(2000000000000)
+
+	underlying := mock.New()
+	cachedStore := cached.New(underlying, 0, 0) // no periodic or threshold flush
+	putBatch(t, cachedStore, testBatch)
+
+	s := mocks.NewStateStore()
+	svc, err := batchservice.New(s, cachedStore, testLog, newMockListener(), nil, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if err := svc.TopUp(testBatch.ID, testNormalisedBalance, testTxHash); err != nil {
 		t.Fatalf("top up: %v", err)
 	}
-	if m := mockHash.ctr; m != 2 {
-		t.Fatalf("expected %d calls got %d", 2, m)
+
+	if _, err := underlying.Get(testBatch.ID); err == nil {
+		t.Fatal("expected underlying store to not be written to before TransactionEnd")
+	}
+
+	if err := svc.TransactionEnd(); err != nil {
+		t.Fatalf("transaction end: %v", err)
 	}
 
-	// now start a new instance and check that the value gets read from statestore
-	store2 := mock.New()
-	mockHash2 := &hs{}
-	_, err = batchservice.New(s, store2, testLog, newMockListener(), nil, nil, func() hash.Hash { return mockHash2 }, false)
+	got, err := underlying.Get(testBatch.ID)
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("expected TransactionEnd to flush the cached store: %v", err)
+	}
+	if got.Value.Cmp(testNormalisedBalance) != 0 {
+		t.Fatalf("flushed batch value: want %v, got %v", testNormalisedBalance, got.Value)
+	}
+}
+
+func TestReorg(t *testing.T) {
+	testBatch := postagetesting.MustNewBatch()
+	initialBalance := big.NewInt(1000)
+	toppedUpBalance := big.NewInt(2000)
+
+	initialChainState := &postage.ChainState{
+		Block:        1,
+		TotalAmount:  big.NewInt(0),
+		CurrentPrice: big.NewInt(100),
 	}
-	if m := mockHash2.ctr; m != 1 {
-		t.Fatalf("expected %d calls got %d", 1, m)
+
+	svc, batchStore, _ := newTestStoreAndService(
+		t,
+		mock.WithChainState(initialChainState),
+	)
+
+	if err := svc.Create(
+		testBatch.ID,
+		testBatch.Owner,
+		initialBalance,
+		testBatch.Depth,
+		testBatch.BucketDepth,
+		testBatch.Immutable,
+		testTxHash,
+	); err != nil {
+		t.Fatalf("create: %v", err)
 	}
 
-	// now start a new instance and check that the value does not get written into the hasher
-	// when resyncing
-	store3 := mock.New()
-	mockHash3 := &hs{}
-	_, err = batchservice.New(s, store3, testLog, newMockListener(), nil, nil, func() hash.Hash { return mockHash3 }, true)
+	if err := svc.UpdateBlockNumber(2); err != nil {
+		t.Fatalf("update block number: %v", err)
+	}
+
+	if err := svc.TopUp(testBatch.ID, toppedUpBalance, testTxHash); err != nil {
+		t.Fatalf("top up: %v", err)
+	}
+
+	if got := batchStore.GetChainState().Block; got != 2 {
+		t.Fatalf("chain state block before reorg: want %v, got %v", 2, got)
+	}
+
+	if err := svc.Reorg(1); err != nil {
+		t.Fatalf("reorg: %v", err)
+	}
+
+	got, err := batchStore.Get(testBatch.ID)
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("batch store get: %v", err)
 	}
-	if m := mockHash3.ctr; m != 0 {
-		t.Fatalf("expected %d calls got %d", 0, m)
+	if got.Value.Cmp(initialBalance) != 0 {
+		t.Fatalf("top up not undone: want %v, got %v", initialBalance, got.Value)
+	}
+
+	if cs := batchStore.GetChainState(); cs.Block != 1 {
+		t.Fatalf("chain state not rewound: want block %v, got %v", 1, cs.Block)
+	}
+}
+
+func TestReorgDropsEventsOlderThanReorgDepth(t *testing.T) {
+	testBatch := postagetesting.MustNewBatch()
+	initialBalance := big.NewInt(1000)
+	toppedUpBalance := big.NewInt(2000)
+
+	initialChainState := &postage.ChainState{
+		Block:        1,
+		TotalAmount:  big.NewInt(0),
+		CurrentPrice: big.NewInt(100),
+	}
+
+	svc, batchStore, _ := newTestStoreAndService(
+		t,
+		mock.WithChainState(initialChainState),
+	)
+	svc.SetReorgDepth(1)
+
+	if err := svc.Create(
+		testBatch.ID,
+		testBatch.Owner,
+		initialBalance,
+		testBatch.Depth,
+		testBatch.BucketDepth,
+		testBatch.Immutable,
+		testTxHash,
+	); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if err := svc.UpdateBlockNumber(2); err != nil {
+		t.Fatalf("update block number: %v", err)
+	}
+
+	// the top up below falls reorgDepth blocks behind the chain tip once
+	// block 3 is reached, so recordEvent will have flushed it out
+	// of the buffer as final and Reorg will no longer be able to undo it.
+	if err := svc.TopUp(testBatch.ID, toppedUpBalance, testTxHash); err != nil {
+		t.Fatalf("top up: %v", err)
+	}
+
+	if err := svc.UpdateBlockNumber(3); err != nil {
+		t.Fatalf("update block number: %v", err)
+	}
+
+	// block 1 is now older than anything the buffer can still vouch for,
+	// since the top up above was evicted as final; Reorg must refuse
+	// rather than silently leave it applied while reporting success.
+	if err := svc.Reorg(1); err == nil {
+		t.Fatal("expected reorg to fail because the buffer no longer covers block 1")
+	}
+
+	got, err := batchStore.Get(testBatch.ID)
+	if err != nil {
+		t.Fatalf("batch store get: %v", err)
+	}
+	if got.Value.Cmp(toppedUpBalance) != 0 {
+		t.Fatalf("failed reorg must not mutate store state: want %v, got %v", toppedUpBalance, got.Value)
 	}
 }
 
@@ -437,7 +666,7 @@ func newTestStoreAndServiceWithListener(
 	owner []byte,
 	batchListener postage.BatchCreationListener,
 	opts ...mock.Option,
-) (postage.EventUpdater, *mock.BatchStore, storage.StateStorer) {
+) (batchservice.EventSource, *mock.BatchStore, storage.StateStorer) {
 	t.Helper()
 	s := mocks.NewStateStore()
 	store := mock.New(opts...)
@@ -449,7 +678,7 @@ func newTestStoreAndServiceWithListener(
 	return svc, store, s
 }
 
-func newTestStoreAndService(t *testing.T, opts ...mock.Option) (postage.EventUpdater, *mock.BatchStore, storage.StateStorer) {
+func newTestStoreAndService(t *testing.T, opts ...mock.Option) (batchservice.EventSource, *mock.BatchStore, storage.StateStorer) {
 	t.Helper()
 	return newTestStoreAndServiceWithListener(t, nil, nil, opts...)
 }
@@ -470,6 +699,27 @@ func putChainState(t *testing.T, store postage.Storer, cs *postage.ChainState) {
 	}
 }
 
+// assertSingleEvent asserts that exactly one event of the given kind is
+// waiting on events, and that nothing else follows it.
+func assertSingleEvent(t *testing.T, events <-chan batchservice.BatchEvent, kind batchservice.EventKind) {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		if ev.Kind() != kind {
+			t.Fatalf("event kind: want %v, got %v", kind, ev.Kind())
+		}
+	default:
+		t.Fatalf("expected an event of kind %v, got none", kind)
+	}
+
+	select {
+	case ev := <-events:
+		t.Fatalf("expected exactly one event, got an extra one of kind %v", ev.Kind())
+	default:
+	}
+}
+
 type hs struct{ ctr uint8 }
 
 func (h *hs) Write(p []byte) (n int, err error) { h.ctr++; return len(p), nil }
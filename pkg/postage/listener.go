@@ -0,0 +1,47 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package postage
+
+import (
+	"io"
+	"math/big"
+)
+
+// EventUpdater is implemented by the batch service and is used by the chain
+// listener to apply on-chain postage events, in the order they occur, to
+// the batch store.
+type EventUpdater interface {
+	Create(id []byte, owner []byte, normalisedBalance *big.Int, depth, bucketDepth uint8, immutable bool, txHash []byte) error
+	TopUp(id []byte, normalisedBalance *big.Int, txHash []byte) error
+	UpdateDepth(id []byte, depth uint8, normalisedBalance *big.Int, txHash []byte) error
+	UpdatePrice(price *big.Int, txHash []byte) error
+	UpdateBlockNumber(blockNumber uint64) error
+	TransactionStart() error
+	TransactionEnd() error
+
+	// Reorg is called by the listener when it detects that the chain has
+	// forked, so that events applied after commonAncestor can be undone
+	// before forward application resumes from there.
+	Reorg(commonAncestor uint64) error
+
+	// Start signals the updater that it should carry out any recovery it
+	// deems necessary and returns a channel that is closed once the updater
+	// considers itself synced to startBlock.
+	Start(startBlock uint64) (<-chan struct{}, error)
+}
+
+// BatchCreationListener is notified whenever a batch is created for the
+// node's own owner address.
+type BatchCreationListener interface {
+	Handle(*Batch)
+}
+
+// Listener watches the chain for postage events from the given block
+// onwards and relays them to the EventUpdater. The returned channel is
+// closed once the listener has caught up with the chain head.
+type Listener interface {
+	io.Closer
+	Listen(from uint64, updater EventUpdater) <-chan struct{}
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package postage contains the data structures and interfaces that
+// describe on-chain postage batches and the chain state the batch
+// store is reconciled against.
+package postage
+
+import "math/big"
+
+// Batch represents a postage batch, a token that enables uploads to the
+// Swarm network. Batches are created, topped up, diluted and otherwise
+// administered through on-chain transactions that are relayed to the
+// node via the chain listener.
+type Batch struct {
+	ID          []byte   // batch ID
+	Owner       []byte   // owner's ethereum address
+	Value       *big.Int // normalised balance of the batch
+	Start       uint64   // block number when the batch was created
+	Depth       uint8    // batch depth, i.e. size = 2^depth
+	BucketDepth uint8    // depth of the neighbourhood bucket used for reserve calc
+	Immutable   bool     // whether the batch can be diluted
+}
+
+// ChainState tracks the chain state relevant for postage batch bookkeeping,
+// namely at which block and under what price the reserve is currently
+// valued.
+type ChainState struct {
+	Block        uint64   // block number of the last postage event seen
+	TotalAmount  *big.Int // cumulative normalised amount paid per stamp
+	CurrentPrice *big.Int // current price per unit of storage
+}
+
+// Storer represents the persisted view of the set of batches and the chain
+// state, as required by the batch service to apply on-chain events.
+type Storer interface {
+	Get(id []byte) (*Batch, error)
+	Put(*Batch, *big.Int, uint8) error
+	Delete(id []byte) error
+	GetChainState() *ChainState
+	PutChainState(*ChainState) error
+	Reset() error
+}
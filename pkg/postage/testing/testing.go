@@ -0,0 +1,42 @@
+// Copyright 2020 The Swarm Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package testing provides convenience constructors for randomised
+// postage fixtures, shared by the postage package and its consumers.
+package testing
+
+import (
+	"math/big"
+	"math/rand"
+
+	"github.com/ethersphere/bee/pkg/postage"
+)
+
+// MustNewBatch creates a new batch with random data.
+func MustNewBatch() *postage.Batch {
+	id := make([]byte, 32)
+	rand.Read(id)
+
+	owner := make([]byte, 32)
+	rand.Read(owner)
+
+	return &postage.Batch{
+		ID:          id,
+		Owner:       owner,
+		Value:       big.NewInt(rand.Int63()),
+		Start:       rand.Uint64(),
+		Depth:       uint8(rand.Intn(30) + 17),
+		BucketDepth: uint8(rand.Intn(16)),
+		Immutable:   rand.Intn(2) == 0,
+	}
+}
+
+// NewChainState creates a new chain state with random values.
+func NewChainState() *postage.ChainState {
+	return &postage.ChainState{
+		Block:        rand.Uint64(),
+		TotalAmount:  big.NewInt(rand.Int63()),
+		CurrentPrice: big.NewInt(rand.Int63()),
+	}
+}